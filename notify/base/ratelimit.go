@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package base
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultRatePerSecond and DefaultBurst match the throttle commonly documented
+// by chat-webhook platforms (e.g. Lark's ~5 messages/second/bot), as used by
+// the bbgo lark notifier.
+const (
+	DefaultRatePerSecond = 5
+	DefaultBurst         = 5
+)
+
+// RateLimit is a token-bucket throttle meant to be embedded by the
+// chat-webhook notifiers (Lark, Slack, DingTalk, WeCom, Discord...) so they
+// all share the same pacing code instead of re-implementing it per notifier.
+type RateLimit struct {
+	RatePerSecond float64 `yaml:"rate,omitempty" json:"rate,omitempty" jsonschema:"title=Rate Per Second,description=Max notifications sent per second (default 5)"`
+	Burst         int     `yaml:"burst,omitempty" json:"burst,omitempty" jsonschema:"title=Burst,description=Max burst size of the rate limiter (default 5)"`
+
+	once    sync.Once
+	limiter *rate.Limiter
+}
+
+// Limiter lazily builds and caches the underlying rate.Limiter, falling back
+// to DefaultRatePerSecond/DefaultBurst when unconfigured. A single RateLimit
+// is shared by every probe referencing the same notify stanza and invoked
+// concurrently, so construction is guarded by sync.Once rather than a plain
+// nil check.
+func (r *RateLimit) Limiter() *rate.Limiter {
+	r.once.Do(func() {
+		ratePerSecond := r.RatePerSecond
+		if ratePerSecond <= 0 {
+			ratePerSecond = DefaultRatePerSecond
+		}
+		burst := r.Burst
+		if burst <= 0 {
+			burst = DefaultBurst
+		}
+		r.limiter = rate.NewLimiter(rate.Limit(ratePerSecond), burst)
+	})
+	return r.limiter
+}
+
+// Wait blocks until the rate limiter permits another notification, or until
+// ctx is done.
+func (r *RateLimit) Wait(ctx context.Context) error {
+	return r.Limiter().Wait(ctx)
+}
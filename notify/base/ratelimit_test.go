@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package base
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitDefaults(t *testing.T) {
+	r := &RateLimit{}
+	l := r.Limiter()
+	if l.Limit() != DefaultRatePerSecond {
+		t.Errorf("rate = %v, want %v", l.Limit(), DefaultRatePerSecond)
+	}
+	if l.Burst() != DefaultBurst {
+		t.Errorf("burst = %v, want %v", l.Burst(), DefaultBurst)
+	}
+}
+
+func TestRateLimitConfigured(t *testing.T) {
+	r := &RateLimit{RatePerSecond: 2, Burst: 1}
+	l := r.Limiter()
+	if l.Limit() != 2 {
+		t.Errorf("rate = %v, want %v", l.Limit(), 2)
+	}
+	if l.Burst() != 1 {
+		t.Errorf("burst = %v, want %v", l.Burst(), 1)
+	}
+}
+
+// TestRateLimitConcurrentLimiter exercises the shared 5/s bucket under
+// concurrent use: every caller must observe the same *rate.Limiter instance.
+func TestRateLimitConcurrentLimiter(t *testing.T) {
+	r := &RateLimit{}
+	var wg sync.WaitGroup
+	limiters := make([]*rate.Limiter, 50)
+	for i := 0; i < len(limiters); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiters[i] = r.Limiter()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < len(limiters); i++ {
+		if limiters[i] != limiters[0] {
+			t.Fatalf("concurrent Limiter() calls returned different instances")
+		}
+	}
+}
@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lark
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easeprobe/global"
+	"github.com/megaease/easeprobe/notify/base"
+	"github.com/megaease/easeprobe/report"
+	log "github.com/sirupsen/logrus"
+)
+
+// These are vars rather than consts so tests can point them at an
+// httptest server.
+var (
+	larkTenantTokenURL = "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal"
+	larkMessageURL     = "https://open.feishu.cn/open-apis/im/v1/messages"
+	larkFileURL        = "https://open.feishu.cn/open-apis/im/v1/files"
+)
+
+// ChatBotConfig is the Lark Open Platform "chat bot" notification. Unlike
+// NotifyConfig, which posts plain text/post/card payloads to custom robot
+// webhooks, ChatBotConfig authenticates as an App (AppID/AppSecret),
+// fetches a tenant_access_token, and posts to a specific chat_id/open_id -
+// optionally @-mentioning users and attaching a file.
+type ChatBotConfig struct {
+	base.DefaultNotify `yaml:",inline"`
+	AppID              string         `yaml:"app_id" json:"app_id" jsonschema:"required,title=App ID,description=The Lark Open Platform App ID"`
+	AppSecret          string         `yaml:"app_secret" json:"app_secret" jsonschema:"required,format=password,title=App Secret,description=The Lark Open Platform App Secret"`
+	ReceiveIDType      string         `yaml:"receive_id_type" json:"receive_id_type" jsonschema:"enum=open_id,enum=user_id,enum=union_id,enum=email,enum=chat_id,title=Receive ID Type,description=The type of ReceiveID (default open_id)"`
+	ReceiveID          string         `yaml:"receive_id" json:"receive_id" jsonschema:"required,title=Receive ID,description=The chat_id/open_id/user_id/union_id/email the message is sent to"`
+	MentionUserIDs     []string       `yaml:"mention_user_ids,omitempty" json:"mention_user_ids,omitempty" jsonschema:"title=Mention User IDs,description=open_ids to at-mention in the message, e.g. the on-call engineer"`
+	AttachmentPath     string         `yaml:"attachment,omitempty" json:"attachment,omitempty" jsonschema:"title=Attachment,description=Path to a file to upload and attach after the text message"`
+	RateLimit          base.RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty" jsonschema:"title=Rate Limit,description=Throttle notifications to stay under the Lark Open Platform API quota"`
+
+	tokenMu     sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// Config configures the lark chat bot notification
+func (c *ChatBotConfig) Config(gConf global.NotifySettings) error {
+	c.NotifyKind = "lark_chatbot"
+	c.NotifyFormat = report.Lark
+	c.NotifySendFunc = c.SendChatBot
+	if c.ReceiveIDType == "" {
+		c.ReceiveIDType = "open_id"
+	}
+	c.DefaultNotify.Config(gConf)
+	log.Debugf("Notification [%s] - [%s] configuration: %+v", c.NotifyKind, c.NotifyName, c)
+	return nil
+}
+
+// tenantTokenResponse is the body of the tenant_access_token/internal response
+type tenantTokenResponse struct {
+	Code              int    `json:"code"`
+	Msg               string `json:"msg"`
+	TenantAccessToken string `json:"tenant_access_token"`
+	Expire            int    `json:"expire"`
+}
+
+// tenantAccessToken returns a cached token, refreshing it once it is within
+// a minute of its reported expiry. c.tokenMu serializes this against
+// concurrent callers sharing the same ChatBotConfig, so the token/expiry
+// pair is never read torn and a refresh is never triggered twice at once.
+func (c *ChatBotConfig) tenantAccessToken() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry.Add(-time.Minute)) {
+		return c.token, nil
+	}
+	return c.fetchTenantAccessToken()
+}
+
+// refreshTenantAccessToken unconditionally fetches a new tenant_access_token,
+// e.g. in response to a 401 from the Open Platform API.
+func (c *ChatBotConfig) refreshTenantAccessToken() (string, error) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	return c.fetchTenantAccessToken()
+}
+
+// fetchTenantAccessToken performs the actual tenant_access_token/internal
+// call. Callers must hold c.tokenMu.
+func (c *ChatBotConfig) fetchTenantAccessToken() (string, error) {
+	body, err := json.Marshal(map[string]string{"app_id": c.AppID, "app_secret": c.AppSecret})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, larkTenantTokenURL, bytes.NewBuffer(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+
+	client := &http.Client{Timeout: c.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var tr tenantTokenResponse
+	if err := json.Unmarshal(buf, &tr); err != nil {
+		return "", fmt.Errorf("[%s / %s] - Error response fetching tenant access token [%d] - [%s]",
+			c.Kind(), c.Name(), resp.StatusCode, string(buf))
+	}
+	if tr.Code != 0 {
+		return "", fmt.Errorf("[%s / %s] - Error fetching tenant access token - code [%d] - msg [%s]",
+			c.Kind(), c.Name(), tr.Code, tr.Msg)
+	}
+
+	c.token = tr.TenantAccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(tr.Expire) * time.Second)
+	return c.token, nil
+}
+
+// chatBotMessage is the im/v1/messages request body. Content is the
+// message-type-specific payload, JSON-encoded into a string as the Lark API
+// requires.
+type chatBotMessage struct {
+	ReceiveID string `json:"receive_id"`
+	MsgType   string `json:"msg_type"`
+	Content   string `json:"content"`
+}
+
+// mentionElements renders one "at" element per configured MentionUserIDs, so
+// probe-down alerts can page the on-call engineer instead of the whole chat.
+func mentionElements(userIDs []string) []report.LarkPostElement {
+	elements := make([]report.LarkPostElement, 0, len(userIDs))
+	for _, id := range userIDs {
+		elements = append(elements, report.LarkPostElement{Tag: report.LarkPostTagAt, UserID: id})
+	}
+	return elements
+}
+
+// SendChatBot sends title/msg as a Lark "post" rich-text message to
+// c.ReceiveID, at-mentioning c.MentionUserIDs, and uploads/attaches
+// c.AttachmentPath when configured.
+func (c *ChatBotConfig) SendChatBot(title, msg string) error {
+	token, err := c.tenantAccessToken()
+	if err != nil {
+		return err
+	}
+
+	post := report.NewLarkPost(title, title, "", msg)
+	if zh, ok := post.Post["zh_cn"]; ok && len(c.MentionUserIDs) > 0 {
+		zh.Content = append(zh.Content, mentionElements(c.MentionUserIDs))
+		post.Post["zh_cn"] = zh
+	}
+
+	if err := c.postMessage(token, "post", post); err != nil {
+		return err
+	}
+
+	if c.AttachmentPath == "" {
+		return nil
+	}
+	fileKey, err := c.uploadFile(token, c.AttachmentPath)
+	if err != nil {
+		return err
+	}
+	return c.postMessage(token, "file", map[string]string{"file_key": fileKey})
+}
+
+// postMessage JSON-encodes content as required by the im/v1/messages API and
+// posts it, refreshing the tenant access token once on a 401 before failing.
+func (c *ChatBotConfig) postMessage(token, msgType string, content interface{}) error {
+	if err := c.RateLimit.Wait(context.Background()); err != nil {
+		return fmt.Errorf("[%s / %s] - Error waiting for rate limiter - %s", c.Kind(), c.Name(), err)
+	}
+
+	contentJSON, err := json.Marshal(content)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(chatBotMessage{
+		ReceiveID: c.ReceiveID,
+		MsgType:   msgType,
+		Content:   string(contentJSON),
+	})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s?receive_id_type=%s", larkMessageURL, c.ReceiveIDType)
+	resp, err := c.doAuthed(token, http.MethodPost, url, "application/json; charset=utf-8", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	if resp.retryToken {
+		token, err = c.refreshTenantAccessToken()
+		if err != nil {
+			return err
+		}
+		resp, err = c.doAuthed(token, http.MethodPost, url, "application/json; charset=utf-8", bytes.NewBuffer(body))
+		if err != nil {
+			return err
+		}
+		if resp.retryToken {
+			return fmt.Errorf("[%s / %s] - tenant access token rejected after refresh, check the App ID/Secret", c.Kind(), c.Name())
+		}
+	}
+	if resp.err != nil {
+		return resp.err
+	}
+	return nil
+}
+
+// uploadFile uploads the file at path to im/v1/files and returns its file_key.
+func (c *ChatBotConfig) uploadFile(token, path string) (string, error) {
+	if err := c.RateLimit.Wait(context.Background()); err != nil {
+		return "", fmt.Errorf("[%s / %s] - Error waiting for rate limiter - %s", c.Kind(), c.Name(), err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.WriteField("file_type", fileTypeOf(path)); err != nil {
+		return "", err
+	}
+	if err := w.WriteField("file_name", filepath.Base(path)); err != nil {
+		return "", err
+	}
+	part, err := w.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	resp, err := c.doAuthed(token, http.MethodPost, larkFileURL, w.FormDataContentType(), &buf)
+	if err != nil {
+		return "", err
+	}
+	if resp.retryToken {
+		token, err = c.refreshTenantAccessToken()
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("[%s / %s] - tenant access token expired mid-upload, retry the notification", c.Kind(), c.Name())
+	}
+	if resp.err != nil {
+		return "", resp.err
+	}
+
+	var fr struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data struct {
+			FileKey string `json:"file_key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp.body, &fr); err != nil {
+		return "", err
+	}
+	if fr.Code != 0 {
+		return "", fmt.Errorf("[%s / %s] - Error uploading file - code [%d] - msg [%s]", c.Kind(), c.Name(), fr.Code, fr.Msg)
+	}
+	return fr.Data.FileKey, nil
+}
+
+// fileTypeOf maps a file extension to the im/v1/files "file_type" field,
+// falling back to the generic "stream" type.
+func fileTypeOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp":
+		return "stream"
+	case ".mp4":
+		return "mp4"
+	case ".pdf":
+		return "pdf"
+	case ".doc", ".docx":
+		return "doc"
+	case ".xls", ".xlsx":
+		return "xls"
+	case ".ppt", ".pptx":
+		return "ppt"
+	default:
+		return "stream"
+	}
+}
+
+// authedResult is the outcome of an authenticated API call: either a usable
+// body, a signal that the token needs refreshing (401), or a terminal error.
+type authedResult struct {
+	body       []byte
+	retryToken bool
+	err        error
+}
+
+// doAuthed performs one Bearer-authenticated HTTP call against the Lark Open
+// Platform API.
+func (c *ChatBotConfig) doAuthed(token, method, url, contentType string, body io.Reader) (*authedResult, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", contentType)
+
+	client := &http.Client{Timeout: c.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return &authedResult{retryToken: true}, nil
+	}
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &authedResult{err: fmt.Errorf("[%s / %s] - Error response from Lark [%d] - [%s]",
+			c.Kind(), c.Name(), resp.StatusCode, string(buf))}, nil
+	}
+	return &authedResult{body: buf}, nil
+}
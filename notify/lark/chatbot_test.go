@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lark
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFileTypeOf(t *testing.T) {
+	testCases := []struct {
+		path string
+		want string
+	}{
+		{"report.png", "stream"},
+		{"video.MP4", "mp4"},
+		{"doc.pdf", "pdf"},
+		{"sheet.xlsx", "xls"},
+		{"slides.pptx", "ppt"},
+		{"notes.docx", "doc"},
+		{"archive.tar.gz", "stream"},
+	}
+
+	for _, tc := range testCases {
+		if got := fileTypeOf(tc.path); got != tc.want {
+			t.Errorf("fileTypeOf(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestTenantAccessTokenCached(t *testing.T) {
+	c := &ChatBotConfig{token: "cached-token", tokenExpiry: time.Now().Add(time.Hour)}
+	got, err := c.tenantAccessToken()
+	if err != nil {
+		t.Fatalf("tenantAccessToken() error = %v", err)
+	}
+	if got != "cached-token" {
+		t.Errorf("tenantAccessToken() = %q, want cached token to be reused", got)
+	}
+}
+
+// TestPostMessagePersistentUnauthorizedReturnsError covers the case where the
+// retried request (after the first 401 triggers a token refresh) also comes
+// back 401 - postMessage must report that as an error, not silently as
+// success.
+func TestPostMessagePersistentUnauthorizedReturnsError(t *testing.T) {
+	msgCalls := 0
+	msgServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msgCalls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer msgServer.Close()
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(tenantTokenResponse{TenantAccessToken: "refreshed-token", Expire: 3600})
+	}))
+	defer tokenServer.Close()
+
+	origMsgURL, origTokenURL := larkMessageURL, larkTenantTokenURL
+	larkMessageURL, larkTenantTokenURL = msgServer.URL, tokenServer.URL
+	defer func() { larkMessageURL, larkTenantTokenURL = origMsgURL, origTokenURL }()
+
+	c := &ChatBotConfig{ReceiveIDType: "open_id", ReceiveID: "ou_123"}
+	c.Timeout = 2 * time.Second
+
+	err := c.postMessage("initial-token", "text", map[string]string{"text": "hi"})
+	if err == nil {
+		t.Fatal("postMessage() error = nil, want an error after a persistent 401")
+	}
+	if msgCalls != 2 {
+		t.Errorf("postMessage() hit the message endpoint %d times, want exactly one retry (2 calls)", msgCalls)
+	}
+}
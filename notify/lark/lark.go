@@ -20,10 +20,17 @@ package lark
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/megaease/easeprobe/global"
 	"github.com/megaease/easeprobe/notify/base"
@@ -31,10 +38,46 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// retryableCodes are the Lark API error codes that indicate the webhook is
+// being throttled, as opposed to a permanent configuration problem.
+var retryableCodes = map[int]bool{
+	9499:  true, // rate limited
+	11232: true, // rate limited
+}
+
+// larkAPIError wraps a non-success response from the Lark webhook so the
+// retry loop in SendLarkNotification can tell throttling/transient failures
+// apart from permanent ones (bad webhook, bad payload, ...).
+type larkAPIError struct {
+	statusCode int
+	code       int
+	msg        string
+}
+
+func (e *larkAPIError) Error() string {
+	return fmt.Sprintf("Error response from Lark [%d] - code [%d] - msg [%v]", e.statusCode, e.code, e.msg)
+}
+
+func (e *larkAPIError) retryable() bool {
+	return e.statusCode >= 500 || retryableCodes[e.code]
+}
+
+// isRetryable reports whether err is worth retrying: a Lark API error flagged
+// as retryable (5xx / rate-limited), or any error that is not a Lark API
+// error (a network failure, a request that could not be built, or a response
+// body that is not JSON).
+func isRetryable(err error) bool {
+	if apiErr, ok := err.(*larkAPIError); ok {
+		return apiErr.retryable()
+	}
+	return true
+}
+
 // Lark is the lark notification
 type Lark struct {
-	MsgType string  `json:"msg_type"`
-	Content Content `json:"content"`
+	MsgType string      `json:"msg_type"`
+	Content interface{} `json:"content,omitempty"`
+	Card    interface{} `json:"card,omitempty"`
 }
 
 // Content is the lark notification content
@@ -42,10 +85,133 @@ type Content struct {
 	Text string `json:"text"`
 }
 
+// MsgType is the type of the Lark message payload
+type MsgType string
+
+// The message types supported by the Lark robot webhook
+const (
+	MsgTypeText        MsgType = "text"
+	MsgTypePost        MsgType = "post"
+	MsgTypeInteractive MsgType = "card"
+)
+
+// wireMsgTypeInteractive is the msg_type Lark's webhook actually expects on
+// the wire for card payloads. It intentionally differs from MsgTypeInteractive,
+// which is the user-facing YAML enum value ("card") kept for readability.
+const wireMsgTypeInteractive = "interactive"
+
 // NotifyConfig is the slack notification configuration
 type NotifyConfig struct {
 	base.DefaultNotify `yaml:",inline"`
-	WebhookURL         string `yaml:"webhook"  json:"webhook" jsonschema:"required,format=uri,title=Webhook URL,description=The Lark Robot Webhook URL"`
+	WebhookURL         string         `yaml:"webhook"  json:"webhook" jsonschema:"required,format=uri,title=Webhook URL,description=The Lark Robot Webhook URL"`
+	MsgType            MsgType        `yaml:"msg_type" json:"msg_type" jsonschema:"enum=text,enum=post,enum=card,title=Message Type,description=The Lark message type - text, post or card (default text)"`
+	Secret             string         `yaml:"secret,omitempty" json:"secret,omitempty" jsonschema:"format=password,title=Secret,description=The signing secret when the Lark Robot 'Signature Verification' security setting is enabled"`
+	Keywords           []string       `yaml:"keywords,omitempty" json:"keywords,omitempty" jsonschema:"title=Keywords,description=The keywords required by the Lark Robot 'Custom Keywords' security setting - every notification must contain at least one"`
+	RateLimit          base.RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty" jsonschema:"title=Rate Limit,description=Throttle notifications to stay under the Lark Robot ~5 messages/second/bot quota"`
+	Routes             []Route        `yaml:"routes,omitempty" json:"routes,omitempty" jsonschema:"title=Routes,description=Per-probe destinations - dispatch to every matching Route's webhook; the default webhook is used only when no Route matches"`
+}
+
+// Severity is the minimum severity a notification must reach for a Route to
+// accept it.
+type Severity string
+
+// The severities a Route's SeverityAtLeast can be set to, ordered low to high.
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarn     Severity = "warn"
+	SeverityCritical Severity = "critical"
+)
+
+var severityRank = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityWarn:     1,
+	SeverityCritical: 2,
+}
+
+// Route is one destination in the routing tree, modeled on alertmanager's
+// route tree: a notification is dispatched to it when every Match label is
+// satisfied and its severity is at least SeverityAtLeast.
+type Route struct {
+	Match           map[string]string `yaml:"match,omitempty" json:"match,omitempty" jsonschema:"title=Match,description=Labels a notification must carry to be sent to this route - only 'kind' (e.g. kind=http) is currently inferred and matchable; other keys are rejected at config time"`
+	SeverityAtLeast Severity          `yaml:"severity_at_least,omitempty" json:"severity_at_least,omitempty" jsonschema:"enum=info,enum=warn,enum=critical,title=Minimum Severity,description=Minimum severity required to dispatch to this route (default info)"`
+	WebhookURL      string            `yaml:"webhook" json:"webhook" jsonschema:"required,format=uri,title=Webhook URL,description=The Lark Robot Webhook URL for this route"`
+	Secret          string            `yaml:"secret,omitempty" json:"secret,omitempty" jsonschema:"format=password,title=Secret,description=The signing secret for this route's webhook"`
+}
+
+// matches reports whether labels/severity satisfy this route.
+func (r *Route) matches(labels map[string]string, severity Severity) bool {
+	if severityRank[severity] < severityRank[r.SeverityAtLeast] {
+		return false
+	}
+	for k, v := range r.Match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// inferSeverity classifies a rendered notification as info/warn/critical from
+// the conventional wording easeprobe's own templates use, since the probe's
+// own status/kind isn't passed to NotifySendFunc.
+func inferSeverity(title, msg string) Severity {
+	text := strings.ToLower(title + "\n" + msg)
+	switch {
+	case strings.Contains(text, "failure") || strings.Contains(text, "down"):
+		return SeverityCritical
+	case strings.Contains(text, "expir") || strings.Contains(text, "warn"):
+		return SeverityWarn
+	default:
+		return SeverityInfo
+	}
+}
+
+// inferLabels best-effort extracts a "kind" label from the rendered
+// notification text, for matching against a Route's Match.
+func inferLabels(title, msg string) map[string]string {
+	text := strings.ToLower(title + " " + msg)
+	labels := map[string]string{}
+	for _, kind := range []string{"http", "tcp", "tls", "ssh", "shell", "host", "native"} {
+		if strings.Contains(text, kind) {
+			labels["kind"] = kind
+			break
+		}
+	}
+	return labels
+}
+
+// supportedMatchKeys are the only labels inferLabels can ever populate. A
+// Route.Match key outside this set (e.g. "tag") can never match anything
+// since there's no probe-tag data to sniff it from - reject it at config
+// time rather than silently always falling through to the default webhook.
+var supportedMatchKeys = map[string]bool{
+	"kind": true,
+}
+
+// validateRoutes rejects any Route whose Match references a label
+// inferLabels doesn't support, per supportedMatchKeys.
+func (c *NotifyConfig) validateRoutes() error {
+	for i, route := range c.Routes {
+		for k := range route.Match {
+			if !supportedMatchKeys[k] {
+				return fmt.Errorf("[%s / %s] - routes[%d].match uses unsupported key %q - only \"kind\" is inferred and matchable",
+					c.Kind(), c.Name(), i, k)
+			}
+		}
+	}
+	return nil
+}
+
+// sampleNotifications are representative renderings of every message shape
+// SendLark actually produces - probe-down, probe-recovery and the periodic
+// SLA summary - each mirroring the wording inferSeverity/isStatusOK key off.
+// validateKeywords is run against all of them at config time so a Keywords
+// set that only happens to match one shape is still caught as a config
+// error up front, before the shape it misses fails silently at send time.
+var sampleNotifications = []struct{ title, msg string }{
+	{"[ProbeName] - Failure", "Down"},
+	{"[ProbeName] - Recovery", "Up"},
+	{"[ProbeName] - SLA Report", "Uptime 99.9%"},
 }
 
 // Config configures the slack notification
@@ -53,32 +219,187 @@ func (c *NotifyConfig) Config(gConf global.NotifySettings) error {
 	c.NotifyKind = "lark"
 	c.NotifyFormat = report.Lark
 	c.NotifySendFunc = c.SendLark
+	if c.MsgType == "" {
+		c.MsgType = MsgTypeText
+	}
+	for _, sample := range sampleNotifications {
+		if err := c.validateKeywords(sample.title, sample.msg); err != nil {
+			return err
+		}
+	}
+	if err := c.validateRoutes(); err != nil {
+		return err
+	}
 	c.DefaultNotify.Config(gConf)
 	log.Debugf("Notification [%s] - [%s] configuration: %+v", c.NotifyKind, c.NotifyName, c)
 	return nil
 }
 
-// SendLark is the wrapper for SendLarkNotification
+// SendLark is the wrapper for SendLarkNotification. NotifySendFunc's signature
+// only carries the rendered title/message, so the labels used for Route
+// matching are inferred from them on a best-effort basis (titles
+// conventionally embed the probe kind and status) rather than from the
+// probe's own tags/kind, which aren't threaded through this entrypoint.
+// validateKeywords also ran at config time against sampleNotifications, but
+// is re-checked here too: a real title/msg can still miss every keyword if
+// it doesn't resemble any of those samples (e.g. a custom probe name), and
+// catching that before Lark's own keyword rejection keeps the failure mode
+// a clear error instead of a retried-then-dropped send.
 func (c *NotifyConfig) SendLark(title, msg string) error {
-	lark := &Lark{
-		MsgType: "text",
-		Content: Content{
-			Text: fmt.Sprintf("%s\n%s", title, msg),
-		},
+	if err := c.validateKeywords(title, msg); err != nil {
+		return err
+	}
+	return c.dispatch(title, msg, inferLabels(title, msg), inferSeverity(title, msg))
+}
+
+// buildLark renders title/msg into the payload matching c.MsgType.
+func (c *NotifyConfig) buildLark(title, msg string) *Lark {
+	switch c.MsgType {
+	case MsgTypePost:
+		return &Lark{
+			MsgType: string(MsgTypePost),
+			Content: report.NewLarkPost(title, title, "", msg),
+		}
+	case MsgTypeInteractive:
+		return &Lark{
+			MsgType: wireMsgTypeInteractive,
+			Card:    report.NewLarkCard(title, msg, isStatusOK(title)),
+		}
+	default:
+		return &Lark{
+			MsgType: string(MsgTypeText),
+			Content: Content{
+				Text: fmt.Sprintf("%s\n%s", title, msg),
+			},
+		}
+	}
+}
+
+// dispatch sends title/msg to every Route whose Match/SeverityAtLeast is
+// satisfied, falling through to the default WebhookURL/Secret when no Route
+// matches (or none are configured) - modeled on alertmanager's route tree.
+func (c *NotifyConfig) dispatch(title, msg string, labels map[string]string, severity Severity) error {
+	lark := c.buildLark(title, msg)
+	var errs []string
+	matched := false
+	for _, route := range c.Routes {
+		if !route.matches(labels, severity) {
+			continue
+		}
+		matched = true
+		if err := c.sendTo(route.WebhookURL, route.Secret, lark); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if !matched {
+		if err := c.sendTo(c.WebhookURL, c.Secret, lark); err != nil {
+			errs = append(errs, err.Error())
+		}
 	}
-	return c.SendLarkNotification(lark)
+	if len(errs) > 0 {
+		return fmt.Errorf("[%s / %s] - %s", c.Kind(), c.Name(), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// isStatusOK guesses the probe status from the rendered title so the card
+// header can be colored red/green without threading the raw status through.
+func isStatusOK(title string) bool {
+	return !strings.Contains(title, "Failure") && !strings.Contains(title, "Down")
+}
+
+// validateKeywords makes sure title/msg satisfies the Lark Robot 'Custom
+// Keywords' security setting, which rejects any message that doesn't
+// literally contain one of the configured keywords. Run from Config against
+// every shape in sampleNotifications to catch most Keywords/template
+// mismatches as a startup-time config error, and again from SendLark against
+// the real rendering in case a shape wasn't anticipated by the samples.
+func (c *NotifyConfig) validateKeywords(title, msg string) error {
+	if len(c.Keywords) == 0 {
+		return nil
+	}
+	text := title + "\n" + msg
+	for _, kw := range c.Keywords {
+		if strings.Contains(text, kw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("[%s / %s] - none of the configured keywords %v found in the notification",
+		c.Kind(), c.Name(), c.Keywords)
+}
+
+// sign computes the Lark Robot 'Signature Verification' HMAC-SHA256 signature
+// (key = timestamp + "\n" + secret, message = "") and attaches it, together with
+// the timestamp it was computed for, as top-level fields of the JSON payload.
+func sign(secret string, data []byte) ([]byte, error) {
+	payload := make(map[string]interface{})
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	key := fmt.Sprintf("%d\n%s", timestamp, secret)
+	h := hmac.New(sha256.New, []byte(key))
+	if _, err := h.Write([]byte{}); err != nil {
+		return nil, err
+	}
+	payload["timestamp"] = strconv.FormatInt(timestamp, 10)
+	payload["sign"] = base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return json.Marshal(payload)
 }
 
 // SendLarkNotification will post to an 'Robot Webhook' url in Lark Apps. It accepts
-// some text and the Lark robot will send it in group.
-func (c *NotifyConfig) SendLarkNotification(lark *Lark) error {
+// the Lark message payload (text, post or card) and the Lark robot will send it in group.
+// It waits on the configured rate limiter before every attempt, and retries
+// with exponential backoff - bounded by c.Timeout - on transient failures.
+func (c *NotifyConfig) SendLarkNotification(lark interface{}) error {
+	return c.sendTo(c.WebhookURL, c.Secret, lark)
+}
+
+// sendTo marshals and posts lark to webhookURL, signing with secret when set.
+// It is the shared path behind both the default webhook and per-Route
+// destinations.
+func (c *NotifyConfig) sendTo(webhookURL, secret string, lark interface{}) error {
 	data, err := json.Marshal(lark)
 	if err != nil {
 		log.Errorf("[%s / %s ] - %v, err - %s", c.Kind(), c.Name(), lark, err)
 		return fmt.Errorf("[%s / %s] - Error from json marshal [%s] - [%s]",
 			c.Kind(), c.Name(), lark, err)
 	}
-	req, err := http.NewRequest(http.MethodPost, c.WebhookURL, bytes.NewBuffer(data))
+
+	if secret != "" {
+		if data, err = sign(secret, data); err != nil {
+			return fmt.Errorf("[%s / %s] - Error signing payload - %s", c.Kind(), c.Name(), err)
+		}
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	backoff := c.Retry.Interval
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		if err := c.RateLimit.Wait(context.Background()); err != nil {
+			return fmt.Errorf("[%s / %s] - Error waiting for rate limiter - %s", c.Kind(), c.Name(), err)
+		}
+
+		sendErr := c.doSend(webhookURL, data)
+		if sendErr == nil {
+			return nil
+		}
+		if attempt >= c.Retry.Times || !isRetryable(sendErr) || time.Now().Add(backoff).After(deadline) {
+			return sendErr
+		}
+
+		log.Warnf("[%s / %s] - %s, retrying in %s", c.Kind(), c.Name(), sendErr, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// doSend performs a single POST of the already-marshaled payload and
+// interprets the Lark response.
+func (c *NotifyConfig) doSend(webhookURL string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewBuffer(data))
 	if err != nil {
 		return err
 	}
@@ -99,6 +420,11 @@ func (c *NotifyConfig) SendLarkNotification(lark *Lark) error {
 	if err != nil {
 		return err
 	}
+
+	if resp.StatusCode >= 500 {
+		return &larkAPIError{statusCode: resp.StatusCode, msg: string(buf)}
+	}
+
 	ret := make(map[string]interface{})
 	err = json.Unmarshal(buf, &ret)
 	if err != nil {
@@ -109,7 +435,7 @@ func (c *NotifyConfig) SendLarkNotification(lark *Lark) error {
 	if statusCode, ok := ret["StatusCode"].(float64); !ok || statusCode != 0 {
 		code, _ := ret["code"].(float64)
 		msg, _ := ret["msg"].(string)
-		return fmt.Errorf("Error response from Lark - code [%d] - msg [%v]", int(code), msg)
+		return &larkAPIError{statusCode: resp.StatusCode, code: int(code), msg: fmt.Sprintf("%v", msg)}
 	}
 	return nil
 }
@@ -0,0 +1,217 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lark
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/megaease/easeprobe/global"
+)
+
+func TestBuildLarkWireMsgType(t *testing.T) {
+	testCases := []struct {
+		msgType MsgType
+		want    string
+	}{
+		{MsgTypeText, "text"},
+		{MsgTypePost, "post"},
+		{MsgTypeInteractive, "interactive"},
+	}
+
+	for _, tc := range testCases {
+		c := &NotifyConfig{MsgType: tc.msgType}
+		got := c.buildLark("title", "msg")
+		if got.MsgType != tc.want {
+			t.Errorf("MsgType %q: got wire msg_type %q, want %q", tc.msgType, got.MsgType, tc.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	data, err := json.Marshal(Content{Text: "hello"})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	signed, err := sign("my-secret", data)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(signed, &payload); err != nil {
+		t.Fatalf("unmarshal signed payload: %v", err)
+	}
+
+	timestamp, ok := payload["timestamp"].(string)
+	if !ok || timestamp == "" {
+		t.Fatalf("signed payload missing timestamp: %v", payload)
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp not an integer: %v", timestamp)
+	}
+
+	key := fmt.Sprintf("%d\n%s", ts, "my-secret")
+	h := hmac.New(sha256.New, []byte(key))
+	want := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	if payload["sign"] != want {
+		t.Errorf("sign = %v, want %v", payload["sign"], want)
+	}
+}
+
+func TestInferSeverity(t *testing.T) {
+	testCases := []struct {
+		title string
+		msg   string
+		want  Severity
+	}{
+		{"probe [web] - Failure", "connection refused", SeverityCritical},
+		{"probe [web] - Down", "", SeverityCritical},
+		{"probe [cert] - TLS expiring soon", "", SeverityWarn},
+		{"probe [web] - Recovery", "all good", SeverityInfo},
+	}
+	for _, tc := range testCases {
+		if got := inferSeverity(tc.title, tc.msg); got != tc.want {
+			t.Errorf("inferSeverity(%q, %q) = %q, want %q", tc.title, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestInferLabels(t *testing.T) {
+	testCases := []struct {
+		title string
+		msg   string
+		want  map[string]string
+	}{
+		{"HTTP probe [web] - Failure", "", map[string]string{"kind": "http"}},
+		{"probe [db] - Failure", "tcp connection refused", map[string]string{"kind": "tcp"}},
+		{"probe [web] - Failure", "unrelated text", map[string]string{}},
+	}
+	for _, tc := range testCases {
+		got := inferLabels(tc.title, tc.msg)
+		if len(got) != len(tc.want) || got["kind"] != tc.want["kind"] {
+			t.Errorf("inferLabels(%q, %q) = %v, want %v", tc.title, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestRouteMatches(t *testing.T) {
+	testCases := []struct {
+		name     string
+		route    Route
+		labels   map[string]string
+		severity Severity
+		want     bool
+	}{
+		{
+			name:     "matching kind and sufficient severity",
+			route:    Route{Match: map[string]string{"kind": "http"}, SeverityAtLeast: SeverityWarn},
+			labels:   map[string]string{"kind": "http"},
+			severity: SeverityCritical,
+			want:     true,
+		},
+		{
+			name:     "severity below threshold",
+			route:    Route{Match: map[string]string{"kind": "http"}, SeverityAtLeast: SeverityCritical},
+			labels:   map[string]string{"kind": "http"},
+			severity: SeverityWarn,
+			want:     false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.route.matches(tc.labels, tc.severity); got != tc.want {
+				t.Errorf("matches() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateRoutesRejectsUnsupportedMatchKey(t *testing.T) {
+	c := &NotifyConfig{
+		Routes: []Route{
+			{Match: map[string]string{"tag": "prod"}, WebhookURL: "https://example.com/hook"},
+		},
+	}
+	if err := c.validateRoutes(); err == nil {
+		t.Fatal("validateRoutes() error = nil, want error for unsupported match key \"tag\"")
+	}
+}
+
+func TestValidateRoutesAcceptsSupportedMatchKey(t *testing.T) {
+	c := &NotifyConfig{
+		Routes: []Route{
+			{Match: map[string]string{"kind": "http"}, WebhookURL: "https://example.com/hook"},
+		},
+	}
+	if err := c.validateRoutes(); err != nil {
+		t.Errorf("validateRoutes() error = %v, want nil for supported match key \"kind\"", err)
+	}
+}
+
+func TestValidateKeywords(t *testing.T) {
+	testCases := []struct {
+		name     string
+		keywords []string
+		title    string
+		msg      string
+		wantErr  bool
+	}{
+		{name: "no keywords configured", keywords: nil, title: "t", msg: "m", wantErr: false},
+		{name: "keyword present in title", keywords: []string{"ALERT"}, title: "ALERT: down", msg: "m", wantErr: false},
+		{name: "keyword present in msg", keywords: []string{"ALERT"}, title: "t", msg: "ALERT triggered", wantErr: false},
+		{name: "no keyword matches", keywords: []string{"ALERT"}, title: "t", msg: "m", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &NotifyConfig{Keywords: tc.keywords}
+			err := c.validateKeywords(tc.title, tc.msg)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("validateKeywords() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// TestConfigRejectsKeywordMatchingOnlyOneShape covers a keyword that only
+// appears in the probe-down sample: Config must still reject it, since the
+// real recovery/SLA-report sends would otherwise fail silently at send time.
+func TestConfigRejectsKeywordMatchingOnlyOneShape(t *testing.T) {
+	c := &NotifyConfig{WebhookURL: "https://example.com/hook", Keywords: []string{"Down"}}
+	if err := c.Config(global.NotifySettings{}); err == nil {
+		t.Fatal("Config() error = nil, want error since \"Down\" doesn't appear in the recovery/SLA samples")
+	}
+}
+
+// TestConfigAcceptsKeywordMatchingEveryShape covers a keyword generic enough
+// to appear in every message shape SendLark produces.
+func TestConfigAcceptsKeywordMatchingEveryShape(t *testing.T) {
+	c := &NotifyConfig{WebhookURL: "https://example.com/hook", Keywords: []string{"ProbeName"}}
+	if err := c.Config(global.NotifySettings{}); err != nil {
+		t.Errorf("Config() error = %v, want nil since \"ProbeName\" appears in every sample title", err)
+	}
+}
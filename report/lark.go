@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+// LarkPostTag is the tag of a Lark "post" rich text element
+type LarkPostTag string
+
+// The tags supported by the Lark "post" rich text message
+const (
+	LarkPostTagText LarkPostTag = "text"
+	LarkPostTagA    LarkPostTag = "a"
+	LarkPostTagAt   LarkPostTag = "at"
+)
+
+// LarkPostElement is one segment of a Lark "post" rich text line
+type LarkPostElement struct {
+	Tag    LarkPostTag `json:"tag"`
+	Text   string      `json:"text,omitempty"`
+	Href   string      `json:"href,omitempty"`
+	UserID string      `json:"user_id,omitempty"`
+}
+
+// LarkPost is the "zh_cn" (or other locale) body of a Lark "post" message
+type LarkPost struct {
+	Title   string              `json:"title"`
+	Content [][]LarkPostElement `json:"content"`
+}
+
+// LarkPostContent is the top level "content" wrapper of a "post" message
+type LarkPostContent struct {
+	Post map[string]LarkPost `json:"post"`
+}
+
+// NewLarkPost builds a "post" rich text payload out of a title and message, with
+// the probe name rendered as a hyperlink when a url is supplied.
+func NewLarkPost(title, name, url, msg string) LarkPostContent {
+	titleLine := []LarkPostElement{{Tag: LarkPostTagText, Text: name}}
+	if url != "" {
+		titleLine = []LarkPostElement{{Tag: LarkPostTagA, Text: name, Href: url}}
+	}
+	return LarkPostContent{
+		Post: map[string]LarkPost{
+			"zh_cn": {
+				Title: title,
+				Content: [][]LarkPostElement{
+					titleLine,
+					{{Tag: LarkPostTagText, Text: msg}},
+				},
+			},
+		},
+	}
+}
+
+// LarkCardHeaderTemplate is the color of a Lark interactive card header
+type LarkCardHeaderTemplate string
+
+// The header colors used to reflect probe status in a Lark card
+const (
+	LarkCardHeaderGreen LarkCardHeaderTemplate = "green"
+	LarkCardHeaderRed   LarkCardHeaderTemplate = "red"
+)
+
+// LarkCardText is a plain text or lark_md text block
+type LarkCardText struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+// LarkCardHeader is the colored title bar of an interactive card
+type LarkCardHeader struct {
+	Title    LarkCardText           `json:"title"`
+	Template LarkCardHeaderTemplate `json:"template"`
+}
+
+// LarkCardElement is one content block of an interactive card
+type LarkCardElement struct {
+	Tag  string       `json:"tag"`
+	Text LarkCardText `json:"text"`
+}
+
+// LarkCard is the "interactive" card payload
+type LarkCard struct {
+	Header   LarkCardHeader    `json:"header"`
+	Elements []LarkCardElement `json:"elements"`
+}
+
+// NewLarkCard builds an interactive card, coloring the header red or green
+// depending on the probe status.
+func NewLarkCard(title, msg string, statusOK bool) LarkCard {
+	template := LarkCardHeaderRed
+	if statusOK {
+		template = LarkCardHeaderGreen
+	}
+	return LarkCard{
+		Header: LarkCardHeader{
+			Title:    LarkCardText{Tag: "plain_text", Content: title},
+			Template: template,
+		},
+		Elements: []LarkCardElement{
+			{Tag: "div", Text: LarkCardText{Tag: "lark_md", Content: msg}},
+		},
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2022, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package report
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNewLarkPostPlainTitle(t *testing.T) {
+	post := NewLarkPost("Probe Failure", "web", "", "connection refused")
+
+	zh, ok := post.Post["zh_cn"]
+	if !ok {
+		t.Fatal(`post.Post["zh_cn"] missing`)
+	}
+	if zh.Title != "Probe Failure" {
+		t.Errorf("Title = %q, want %q", zh.Title, "Probe Failure")
+	}
+	if len(zh.Content) != 2 {
+		t.Fatalf("Content has %d lines, want 2", len(zh.Content))
+	}
+
+	titleLine := zh.Content[0]
+	if len(titleLine) != 1 || titleLine[0].Tag != LarkPostTagText || titleLine[0].Text != "web" {
+		t.Errorf("title line = %+v, want a single text segment %q", titleLine, "web")
+	}
+
+	msgLine := zh.Content[1]
+	if len(msgLine) != 1 || msgLine[0].Tag != LarkPostTagText || msgLine[0].Text != "connection refused" {
+		t.Errorf("msg line = %+v, want a single text segment %q", msgLine, "connection refused")
+	}
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if _, ok := decoded["post"]; !ok {
+		t.Errorf("marshaled JSON missing top-level \"post\" key: %s", data)
+	}
+}
+
+// TestNewLarkPostHyperlink covers the href branch of NewLarkPost - dead code
+// as far as any real caller in this repo goes (both lark.go and chatbot.go
+// always pass url=""), but it's still part of the wire format this notifier
+// claims to support, so it must render the "a" tag/href Lark expects.
+func TestNewLarkPostHyperlink(t *testing.T) {
+	post := NewLarkPost("Probe Failure", "web", "https://example.com/probes/web", "connection refused")
+
+	zh, ok := post.Post["zh_cn"]
+	if !ok {
+		t.Fatal(`post.Post["zh_cn"] missing`)
+	}
+
+	titleLine := zh.Content[0]
+	if len(titleLine) != 1 {
+		t.Fatalf("title line has %d segments, want 1", len(titleLine))
+	}
+	link := titleLine[0]
+	if link.Tag != LarkPostTagA {
+		t.Errorf("Tag = %q, want %q", link.Tag, LarkPostTagA)
+	}
+	if link.Text != "web" {
+		t.Errorf("Text = %q, want %q", link.Text, "web")
+	}
+	if link.Href != "https://example.com/probes/web" {
+		t.Errorf("Href = %q, want %q", link.Href, "https://example.com/probes/web")
+	}
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded struct {
+		Post map[string]struct {
+			Content [][]struct {
+				Tag  string `json:"tag"`
+				Href string `json:"href"`
+			} `json:"content"`
+		} `json:"post"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	segment := decoded.Post["zh_cn"].Content[0][0]
+	if segment.Tag != "a" || segment.Href != "https://example.com/probes/web" {
+		t.Errorf("marshaled title segment = %+v, want tag \"a\" with the href set", segment)
+	}
+}
+
+func TestNewLarkCard(t *testing.T) {
+	testCases := []struct {
+		name         string
+		statusOK     bool
+		wantTemplate LarkCardHeaderTemplate
+	}{
+		{"probe up", true, LarkCardHeaderGreen},
+		{"probe down", false, LarkCardHeaderRed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			card := NewLarkCard("Probe Status", "all good", tc.statusOK)
+
+			if card.Header.Template != tc.wantTemplate {
+				t.Errorf("Header.Template = %q, want %q", card.Header.Template, tc.wantTemplate)
+			}
+			if card.Header.Title.Content != "Probe Status" {
+				t.Errorf("Header.Title.Content = %q, want %q", card.Header.Title.Content, "Probe Status")
+			}
+			if len(card.Elements) != 1 || card.Elements[0].Text.Content != "all good" {
+				t.Errorf("Elements = %+v, want a single element with content %q", card.Elements, "all good")
+			}
+
+			data, err := json.Marshal(card)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var decoded map[string]interface{}
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			header, ok := decoded["header"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("marshaled JSON missing \"header\": %s", data)
+			}
+			if header["template"] != string(tc.wantTemplate) {
+				t.Errorf("marshaled header.template = %v, want %q", header["template"], tc.wantTemplate)
+			}
+		})
+	}
+}